@@ -0,0 +1,41 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package params
+
+// EntityLogRequest holds parameters for filtering and reconfiguring
+// a debug-log stream. A zero value matches every line. It is sent as
+// a JSON request over the debug-log websocket once the connection
+// has been established, and may be sent again at any time to adjust
+// the stream's filters without reconnecting.
+type EntityLogRequest struct {
+	// Filter holds a regular expression matched against each log
+	// line verbatim. It is retained for backwards compatibility
+	// with clients that do not use the structured filters below.
+	Filter string
+
+	// Level restricts the stream to log records at or above the
+	// given severity (TRACE, DEBUG, INFO, WARNING, ERROR or
+	// CRITICAL). An empty value means no severity filtering.
+	Level string
+
+	// IncludeModule and ExcludeModule hold glob patterns matched
+	// against a log record's logging module (e.g. "juju.worker.*").
+	// A record is delivered only if it matches at least one
+	// IncludeModule pattern (when any are given) and none of the
+	// ExcludeModule patterns.
+	IncludeModule []string
+	ExcludeModule []string
+
+	// IncludeEntity and ExcludeEntity hold glob patterns matched
+	// against the tag of the entity (machine or unit) that emitted
+	// the log record.
+	IncludeEntity []string
+	ExcludeEntity []string
+}
+
+// EntityLogResponse holds an error that occurred while processing a
+// debug-log request.
+type EntityLogResponse struct {
+	Error string
+}
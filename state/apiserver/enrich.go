@@ -0,0 +1,171 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru"
+
+	"launchpad.net/juju-core/names"
+	"launchpad.net/juju-core/state"
+)
+
+const (
+	// entityCacheSize bounds the number of resolved entities kept in
+	// memory at once, across all debug-log streams.
+	entityCacheSize = 4096
+
+	// entityCacheTTL is how long a resolved entity is trusted before
+	// it is looked up again.
+	entityCacheTTL = 30 * time.Second
+)
+
+// entityInfo holds the contextual metadata a debug-log JSON envelope
+// is decorated with when enrich=true is requested.
+type entityInfo struct {
+	CharmURL    string `json:"charm_url,omitempty"`
+	Application string `json:"application,omitempty"`
+	Series      string `json:"series,omitempty"`
+	ModelName   string `json:"model_name,omitempty"`
+	ModelUUID   string `json:"model_uuid,omitempty"`
+	UnitStatus  string `json:"unit_status,omitempty"`
+}
+
+// entityCacheEntry is the value stored in entityCache.
+type entityCacheEntry struct {
+	info    *entityInfo
+	expires time.Time
+}
+
+var (
+	entityCacheOnce sync.Once
+	entityCache     *lru.Cache
+
+	// entityLookupsInFlight tracks the tags currently being resolved,
+	// so a hot entity logging many lines per second coalesces onto a
+	// single outstanding lookup instead of spawning one per line.
+	entityLookupsMu       sync.Mutex
+	entityLookupsInFlight = make(map[string]bool)
+)
+
+// getEntityCache returns the process-wide entity metadata cache,
+// initialising it on first use. It is shared by every debug-log
+// stream so that enrichment under high log volume does not turn
+// into a Mongo lookup per line.
+func getEntityCache() *lru.Cache {
+	entityCacheOnce.Do(func() {
+		cache, err := lru.New(entityCacheSize)
+		if err != nil {
+			// Only fails for a non-positive size.
+			panic(err)
+		}
+		entityCache = cache
+	})
+	return entityCache
+}
+
+// entityResolver resolves entity tags seen in the log stream against
+// state, for the purposes of log enrichment.
+type entityResolver struct {
+	st *state.State
+}
+
+// resolve returns the cached metadata for tag, if any is cached and
+// still fresh. On a cache miss or a stale entry it kicks off an
+// asynchronous lookup to populate the cache for next time -- unless
+// one is already outstanding for tag, in which case it leaves that
+// one to finish rather than starting another -- and reports false,
+// so the caller can emit the line un-enriched rather than block the
+// tailer on a Mongo round trip.
+func (r *entityResolver) resolve(tag string) (*entityInfo, bool) {
+	cache := getEntityCache()
+	if v, ok := cache.Get(tag); ok {
+		entry := v.(*entityCacheEntry)
+		if time.Now().Before(entry.expires) {
+			return entry.info, true
+		}
+	}
+	if r.startLookup(tag) {
+		go r.lookupAsync(tag)
+	}
+	return nil, false
+}
+
+// startLookup records tag as having a lookup in flight and reports
+// true, unless one is already outstanding, in which case it reports
+// false and leaves the existing lookup to finish.
+func (r *entityResolver) startLookup(tag string) bool {
+	entityLookupsMu.Lock()
+	defer entityLookupsMu.Unlock()
+	if entityLookupsInFlight[tag] {
+		return false
+	}
+	entityLookupsInFlight[tag] = true
+	return true
+}
+
+// finishLookup clears tag's in-flight marker, allowing a future
+// resolve to trigger a fresh lookup.
+func finishLookup(tag string) {
+	entityLookupsMu.Lock()
+	defer entityLookupsMu.Unlock()
+	delete(entityLookupsInFlight, tag)
+}
+
+// lookupAsync resolves tag and stores the result in the cache. It is
+// run in its own goroutine by resolve so that a slow or failing
+// lookup never blocks log delivery.
+func (r *entityResolver) lookupAsync(tag string) {
+	defer finishLookup(tag)
+	info, err := r.lookup(tag)
+	if err != nil {
+		logger.Debugf("cannot resolve entity %q for log enrichment: %v", tag, err)
+		return
+	}
+	getEntityCache().Add(tag, &entityCacheEntry{
+		info:    info,
+		expires: time.Now().Add(entityCacheTTL),
+	})
+}
+
+// lookup resolves tag against state, synchronously.
+func (r *entityResolver) lookup(tag string) (*entityInfo, error) {
+	entityTag, err := names.ParseTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	env, err := r.st.Environment()
+	if err != nil {
+		return nil, err
+	}
+	info := &entityInfo{
+		ModelName: env.Name(),
+		ModelUUID: env.UUID(),
+	}
+	switch entityTag := entityTag.(type) {
+	case names.MachineTag:
+		m, err := r.st.Machine(entityTag.Id())
+		if err != nil {
+			return nil, err
+		}
+		info.Series = m.Series()
+	case names.UnitTag:
+		u, err := r.st.Unit(entityTag.Id())
+		if err != nil {
+			return nil, err
+		}
+		info.Application = u.ServiceName()
+		if status, err := u.Status(); err == nil {
+			info.UnitStatus = string(status.Status)
+		}
+		if svc, err := r.st.Service(u.ServiceName()); err == nil {
+			if curl, _ := svc.CharmURL(); curl != nil {
+				info.CharmURL = curl.String()
+			}
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package httpmetrics provides a prometheus.Collector that
+// instruments the apiserver's plain HTTP and websocket endpoints,
+// such as the debug-log handler.
+package httpmetrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "juju_apiserver_http"
+
+	endpointLabel = "endpoint"
+	outcomeLabel  = "outcome"
+)
+
+var endpointLabelNames = []string{endpointLabel}
+
+// Collector is a prometheus.Collector that collects metrics about
+// the apiserver's HTTP and websocket endpoints. Unlike
+// state/statemetrics, the values here are updated live by the
+// handlers as requests are served, rather than computed when
+// Collect is called; Collect only forwards the current values.
+type Collector struct {
+	scrapeDuration prometheus.Gauge
+	scrapeErrors   prometheus.Gauge
+
+	requestsTotal       *prometheus.CounterVec
+	authFailuresTotal   *prometheus.CounterVec
+	activeConnections   *prometheus.GaugeVec
+	bytesStreamedTotal  *prometheus.CounterVec
+	linesFilteredTotal  *prometheus.CounterVec
+	linesDeliveredTotal *prometheus.CounterVec
+	tailerErrorsTotal   *prometheus.CounterVec
+}
+
+// New returns a new Collector.
+func New() *Collector {
+	return &Collector{
+		scrapeDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "scrape_duration_seconds",
+			Help:      "Amount of time taken to collect apiserver HTTP metrics.",
+		}),
+		scrapeErrors: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "scrape_errors",
+			Help:      "Number of errors observed while collecting apiserver HTTP metrics.",
+		}),
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Number of HTTP requests received, by endpoint and auth outcome.",
+		}, []string{endpointLabel, outcomeLabel}),
+		authFailuresTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "auth_failures_total",
+			Help:      "Number of requests that failed authentication, by endpoint.",
+		}, endpointLabelNames),
+		activeConnections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_connections",
+			Help:      "Number of currently open websocket streams, by endpoint.",
+		}, endpointLabelNames),
+		bytesStreamedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bytes_streamed_total",
+			Help:      "Number of bytes streamed to clients, by endpoint.",
+		}, endpointLabelNames),
+		linesFilteredTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "lines_filtered_total",
+			Help:      "Number of log lines suppressed by a filter, by endpoint.",
+		}, endpointLabelNames),
+		linesDeliveredTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "lines_delivered_total",
+			Help:      "Number of log lines delivered to clients, by endpoint.",
+		}, endpointLabelNames),
+		tailerErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tailer_errors_total",
+			Help:      "Number of errors encountered reading the tailed file, by endpoint.",
+		}, endpointLabelNames),
+	}
+}
+
+// Describe is part of the prometheus.Collector interface.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.requestsTotal.Describe(ch)
+	c.authFailuresTotal.Describe(ch)
+	c.activeConnections.Describe(ch)
+	c.bytesStreamedTotal.Describe(ch)
+	c.linesFilteredTotal.Describe(ch)
+	c.linesDeliveredTotal.Describe(ch)
+	c.tailerErrorsTotal.Describe(ch)
+
+	c.scrapeErrors.Describe(ch)
+	c.scrapeDuration.Describe(ch)
+}
+
+// Collect is part of the prometheus.Collector interface.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	defer func() {
+		c.scrapeDuration.Set(time.Since(start).Seconds())
+		c.scrapeDuration.Collect(ch)
+	}()
+	c.scrapeErrors.Set(0)
+	defer c.scrapeErrors.Collect(ch)
+
+	c.requestsTotal.Collect(ch)
+	c.authFailuresTotal.Collect(ch)
+	c.activeConnections.Collect(ch)
+	c.bytesStreamedTotal.Collect(ch)
+	c.linesFilteredTotal.Collect(ch)
+	c.linesDeliveredTotal.Collect(ch)
+	c.tailerErrorsTotal.Collect(ch)
+}
+
+// RecordRequest records an HTTP request to endpoint, noting whether
+// it passed authentication.
+func (c *Collector) RecordRequest(endpoint string, authenticated bool) {
+	outcome := "success"
+	if !authenticated {
+		outcome = "auth_error"
+		c.authFailuresTotal.WithLabelValues(endpoint).Inc()
+	}
+	c.requestsTotal.WithLabelValues(endpoint, outcome).Inc()
+}
+
+// ConnectionOpened increments the active websocket connection gauge
+// for endpoint. The caller must arrange for ConnectionClosed to be
+// called exactly once for every ConnectionOpened call.
+func (c *Collector) ConnectionOpened(endpoint string) {
+	c.activeConnections.WithLabelValues(endpoint).Inc()
+}
+
+// ConnectionClosed decrements the active websocket connection gauge
+// for endpoint.
+func (c *Collector) ConnectionClosed(endpoint string) {
+	c.activeConnections.WithLabelValues(endpoint).Dec()
+}
+
+// BytesStreamed records n bytes having been streamed to a client of
+// endpoint.
+func (c *Collector) BytesStreamed(endpoint string, n int) {
+	c.bytesStreamedTotal.WithLabelValues(endpoint).Add(float64(n))
+}
+
+// LineFiltered records a log line that was suppressed by a filter
+// before being delivered to a client of endpoint.
+func (c *Collector) LineFiltered(endpoint string) {
+	c.linesFilteredTotal.WithLabelValues(endpoint).Inc()
+}
+
+// LineDelivered records a log line that was delivered to a client of
+// endpoint.
+func (c *Collector) LineDelivered(endpoint string) {
+	c.linesDeliveredTotal.WithLabelValues(endpoint).Inc()
+}
+
+// TailerError records an error encountered reading the tailed file
+// backing endpoint.
+func (c *Collector) TailerError(endpoint string) {
+	c.tailerErrorsTotal.WithLabelValues(endpoint).Inc()
+}
@@ -0,0 +1,78 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package httpmetrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	gc "gopkg.in/check.v1"
+)
+
+// drain reads every metric Collect sends, the way a real prometheus
+// registry would, so Collect is exercised under a concurrent writer
+// the same as it would be during a live scrape.
+func drain(c *Collector) {
+	ch := make(chan prometheus.Metric, 64)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	for range ch {
+	}
+}
+
+func (s *httpmetricsSuite) TestConcurrentRecordingDoesNotRaceOrPanic(c *gc.C) {
+	collector := New()
+	const endpoint = "debug-log"
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			collector.RecordRequest(endpoint, i%2 == 0)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			collector.ConnectionOpened(endpoint)
+			collector.ConnectionClosed(endpoint)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			collector.BytesStreamed(endpoint, 128)
+			collector.LineDelivered(endpoint)
+			collector.LineFiltered(endpoint)
+			collector.TailerError(endpoint)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			drain(collector)
+		}
+	}()
+	wg.Wait()
+
+	drain(collector)
+}
+
+func (s *httpmetricsSuite) TestDescribeSendsEveryMetricsDescriptor(c *gc.C) {
+	collector := New()
+	ch := make(chan *prometheus.Desc, 64)
+	go func() {
+		collector.Describe(ch)
+		close(ch)
+	}()
+	var got int
+	for range ch {
+		got++
+	}
+	// scrapeDuration, scrapeErrors, and the seven per-endpoint vecs.
+	c.Assert(got, gc.Equals, 9)
+}
@@ -4,19 +4,23 @@
 package apiserver
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"regexp"
 	"strconv"
 	"sync"
+	"time"
 
 	"code.google.com/p/go.net/websocket"
+	"golang.org/x/net/context"
 
 	"launchpad.net/juju-core/state"
 	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/httpmetrics"
 	"launchpad.net/juju-core/utils/tailer"
+	"launchpad.net/loggo"
 	"launchpad.net/tomb"
 )
 
@@ -25,29 +29,65 @@ import (
 // lp:1202682.
 const logLocation = "/var/log/juju/all-machines.log"
 
+// debugLogEndpoint identifies the debug-log handler in metrics
+// emitted via httpmetrics.
+const debugLogEndpoint = "debug-log"
+
+// logSourceOpenTimeout bounds how long opening a log source may take.
+// Some sources (journaldLogSource, multiFileLogSource) do synchronous
+// reads of potentially large amounts of data before the websocket
+// upgrades, so this is what makes their ctx.Err() checks meaningful.
+const logSourceOpenTimeout = 30 * time.Second
+
 // logHandler takes requests to watch the debug log.
 type logHandler struct {
 	commonHandler
+	metrics *httpmetrics.Collector
+	state   *state.State
+	source  LogSource
 }
 
 // newLogHandler returns a new http.Handler
-// that handles debug-log HTTP requests.
-func newLogHandler(state *state.State) *logHandler {
-	return &logHandler{commonHandler{state}}
+// that handles debug-log HTTP requests, reading from source. Use
+// newDefaultLogSource(logLocation) to pick source the way the server
+// normally would (plain file, merged rotated backups, or a journald
+// fallback), or one of the newFooLogSource constructors directly to
+// force a specific backend.
+func newLogHandler(st *state.State, metrics *httpmetrics.Collector, source LogSource) *logHandler {
+	return &logHandler{
+		commonHandler: commonHandler{st},
+		metrics:       metrics,
+		state:         st,
+		source:        source,
+	}
+}
+
+// debugLogMetrics is the process-wide httpmetrics.Collector for the
+// debug-log endpoint. It must be constructed once and shared by
+// every debug-log handler the apiserver builds, so that its
+// Prometheus counters and gauges aggregate across connections
+// instead of resetting each time a handler is built; apiserver
+// construction is expected to register it with the server's
+// Prometheus registry alongside the other collectors.
+var debugLogMetrics = httpmetrics.New()
+
+// newDebugLogHandler returns the *logHandler apiserver construction
+// should register for debug-log requests against st -- wired up with
+// the shared debugLogMetrics and the log source newDefaultLogSource
+// picks for logLocation. It exists so that construction has exactly
+// one thing to call rather than assembling a metrics collector and a
+// log source itself.
+func newDebugLogHandler(st *state.State) *logHandler {
+	return newLogHandler(st, debugLogMetrics, newDefaultLogSource(logLocation))
 }
 
 func (h *logHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if err := h.authenticate(req); err != nil {
+		h.metrics.RecordRequest(debugLogEndpoint, false)
 		h.sendAuthError(h, w)
 		return
 	}
-	// Open log file.
-	logFile, err := os.Open(logLocation)
-	if err != nil {
-		h.sendError(h, w, http.StatusInternalServerError, "cannot open log file: %v", err)
-		return
-	}
-	defer logFile.Close()
+	h.metrics.RecordRequest(debugLogEndpoint, true)
 	// Get the arguments of the request.
 	values := req.URL.Query()
 	lines := 0
@@ -60,10 +100,58 @@ func (h *logHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 	filter := values.Get("filter")
+	format := values.Get("format")
+	if format != "" && format != "json" {
+		h.sendError(h, w, http.StatusInternalServerError, "unknown log format %q", format)
+		return
+	}
+	enrich := values.Get("enrich") == "true"
+	if enrich && format != "json" {
+		h.sendError(h, w, http.StatusInternalServerError, "enrich=true requires format=json")
+		return
+	}
+	since, err := parseLogTime(values.Get("since"))
+	if err != nil {
+		h.sendError(h, w, http.StatusInternalServerError, "cannot parse since: %v", err)
+		return
+	}
+	until, err := parseLogTime(values.Get("until"))
+	if err != nil {
+		h.sendError(h, w, http.StatusInternalServerError, "cannot parse until: %v", err)
+		return
+	}
+	noTail := values.Get("no_tail") == "true"
+	// Open the log source, bounding how long its (possibly slow,
+	// synchronous) setup work may run for. since is passed through so
+	// a source merging rotated backups (see multiFileLogSource) can
+	// skip ones it can tell are entirely before it.
+	openCtx, cancel := context.WithTimeout(context.Background(), logSourceOpenTimeout)
+	defer cancel()
+	logFile, err := h.source.Open(openCtx, since)
+	if err != nil {
+		h.sendError(h, w, http.StatusInternalServerError, "cannot open log source: %v", err)
+		return
+	}
+	defer logFile.Close()
+	if !h.source.Follow() {
+		// This source can only ever replay a bounded snapshot, so
+		// there's nothing to follow regardless of what was asked.
+		noTail = true
+	}
 	// Start streaming.
 	wsServer := websocket.Server{
 		Handler: func(wsConn *websocket.Conn) {
-			stream := &logStream{}
+			h.metrics.ConnectionOpened(debugLogEndpoint)
+			defer h.metrics.ConnectionClosed(debugLogEndpoint)
+			stream := &logStream{
+				format:   format,
+				metrics:  h.metrics,
+				enrich:   enrich,
+				resolver: &entityResolver{st: h.state},
+				since:    since,
+				until:    until,
+				noTail:   noTail,
+			}
 			go stream.loop(logFile, wsConn, lines, filter)
 			if err := stream.tomb.Wait(); err != nil {
 				logger.Errorf("debug-log handler error: %v", err)
@@ -81,10 +169,31 @@ func (h *logHandler) errorResponse(message string) interface{} {
 // logStream runs the tailer to read a log file and stream
 // it via a web socket.
 type logStream struct {
-	tomb     tomb.Tomb
-	mux      sync.Mutex
-	filter   string
-	filterRx *regexp.Regexp
+	tomb    tomb.Tomb
+	mux     sync.Mutex
+	format  string
+	metrics *httpmetrics.Collector
+
+	// enrich and resolver control decoration of JSON envelopes with
+	// contextual entity metadata; see entityResolver.
+	enrich   bool
+	resolver *entityResolver
+
+	// since, until and noTail implement replay/seek: since and until
+	// bound the window of lines delivered (the zero Time means no
+	// bound), and noTail stops the stream at EOF instead of
+	// following the file forever.
+	since  time.Time
+	until  time.Time
+	noTail bool
+
+	filter        string
+	filterRx      *regexp.Regexp
+	level         loggo.Level
+	includeModule []string
+	excludeModule []string
+	includeEntity []string
+	excludeEntity []string
 }
 
 // loop starts the tailer with the log file and the web socket.
@@ -94,39 +203,186 @@ func (stream *logStream) loop(logFile io.ReadSeeker, wsConn *websocket.Conn, lin
 		stream.tomb.Kill(err)
 		return
 	}
-	tailer := tailer.NewTailer(logFile, wsConn, lines, stream.filterLine)
+	if !stream.since.IsZero() {
+		if err := seekLogFile(logFile, stream.since); err != nil {
+			stream.tomb.Kill(err)
+			return
+		}
+		// since has already selected our starting point; it has no
+		// further use as a tail-count.
+		lines = 0
+	}
+	var writer io.Writer = wsConn
+	if stream.format == "json" {
+		lineWriter := &logLineWriter{wsConn: wsConn}
+		if stream.enrich {
+			lineWriter.resolver = stream.resolver
+		}
+		writer = lineWriter
+	}
+	writer = &meteringWriter{Writer: writer, metrics: stream.metrics}
+
+	if stream.noTail {
+		stream.readUntilEOF(logFile, writer, lines)
+		return
+	}
+
+	tailer := tailer.NewTailer(logFile, writer, lines, stream.filterLine)
 	go stream.handleRequests(wsConn)
 	select {
 	case <-tailer.Dead():
+		if err := tailer.Err(); err != nil {
+			stream.metrics.TailerError(debugLogEndpoint)
+		}
 		stream.tomb.Kill(tailer.Err())
 	case <-stream.tomb.Dying():
 		tailer.Stop()
 	}
 }
 
-// filterLine checks the received line for one of the confgured tags.
+// readUntilEOF reads logFile to the end, delivering lines that pass
+// the stream's filters, and then stops -- it does not follow the
+// file for new writes. It is used instead of the tailer when
+// no_tail was requested. If lines is positive, only the last lines
+// matching lines are delivered, same as the tailer would for a live
+// stream; a since seek already positions the read and passes lines
+// as 0, meaning deliver everything from there.
+func (stream *logStream) readUntilEOF(logFile io.Reader, writer io.Writer, lines int) {
+	var tail [][]byte
+	flush := func() error {
+		for _, line := range tail {
+			if _, err := writer.Write(line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	scanner := bufio.NewScanner(logFile)
+	for scanner.Scan() {
+		line := append(append([]byte(nil), scanner.Bytes()...), '\n')
+		if stream.filterLine(line) {
+			if lines <= 0 {
+				if _, err := writer.Write(line); err != nil {
+					stream.tomb.Kill(err)
+					return
+				}
+			} else {
+				tail = append(tail, line)
+				if len(tail) > lines {
+					tail = tail[1:]
+				}
+			}
+		}
+		if stream.tomb.Err() != tomb.ErrStillAlive {
+			// matches found the until boundary and killed the tomb.
+			flush()
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		stream.metrics.TailerError(debugLogEndpoint)
+		stream.tomb.Kill(err)
+		return
+	}
+	if err := flush(); err != nil {
+		stream.tomb.Kill(err)
+		return
+	}
+	stream.tomb.Kill(nil)
+}
+
+// filterLine checks the received line against the configured
+// regular expression filter as well as the structured severity,
+// module and entity filters, recording the outcome in the stream's
+// metrics.
 func (stream *logStream) filterLine(line []byte) bool {
+	ok := stream.matches(line)
+	if ok {
+		stream.metrics.LineDelivered(debugLogEndpoint)
+	} else {
+		stream.metrics.LineFiltered(debugLogEndpoint)
+	}
+	return ok
+}
+
+// matches reports whether line passes the stream's current filters.
+func (stream *logStream) matches(line []byte) bool {
 	stream.mux.Lock()
 	defer stream.mux.Unlock()
-	// Check if no filter.
-	if stream.filterRx == nil {
+	if stream.filterRx != nil && !stream.filterRx.Match(line) {
+		return false
+	}
+	rec, ok := parseLogLine(line)
+	if !ok {
+		// Can't be parsed into structured fields, so only the
+		// regular expression filter above applies to it.
 		return true
 	}
-	// Check if the filter matches.
-	return stream.filterRx.Match(line)
+	if !stream.until.IsZero() {
+		// An unparseable timestamp is treated as matching any
+		// window, consistent with seekLogFile's handling of clock
+		// skew; only a timestamp we can parse can end the stream.
+		if ts, err := time.Parse(logLineTimeFormat, rec.Timestamp); err == nil && ts.After(stream.until) {
+			stream.tomb.Kill(nil)
+			return false
+		}
+	}
+	if stream.level != loggo.UNSPECIFIED {
+		if lvl, ok := loggo.ParseLevel(rec.Severity); ok && lvl < stream.level {
+			return false
+		}
+	}
+	if len(stream.includeModule) > 0 && !matchesAny(stream.includeModule, rec.Module) {
+		return false
+	}
+	if matchesAny(stream.excludeModule, rec.Module) {
+		return false
+	}
+	if len(stream.includeEntity) > 0 && !matchesAny(stream.includeEntity, rec.Entity) {
+		return false
+	}
+	if matchesAny(stream.excludeEntity, rec.Entity) {
+		return false
+	}
+	return true
+}
+
+// setFilter configures the stream filtering by setting the regular
+// expression to filter on. It is kept for the initial "filter" query
+// parameter; richer filtering is configured via configure.
+func (stream *logStream) setFilter(filter string) error {
+	return stream.configure(params.EntityLogRequest{Filter: filter})
 }
 
-// setFilter configures the stream filtering by setting the
-// tags to filter.
-func (stream *logStream) setFilter(filter string) (err error) {
+// configure replaces the stream's filters with those described by
+// req, using the same mutex discipline as setFilter so that it may
+// be called concurrently with filterLine.
+func (stream *logStream) configure(req params.EntityLogRequest) error {
+	filterRx, err := regexp.Compile(req.Filter)
+	if err != nil {
+		return err
+	}
+	level := loggo.UNSPECIFIED
+	if req.Level != "" {
+		var ok bool
+		if level, ok = loggo.ParseLevel(req.Level); !ok {
+			return fmt.Errorf("unknown log level %q", req.Level)
+		}
+	}
 	stream.mux.Lock()
 	defer stream.mux.Unlock()
-	stream.filterRx, err = regexp.Compile(filter)
-	return
+	stream.filter = req.Filter
+	stream.filterRx = filterRx
+	stream.level = level
+	stream.includeModule = req.IncludeModule
+	stream.excludeModule = req.ExcludeModule
+	stream.includeEntity = req.IncludeEntity
+	stream.excludeEntity = req.ExcludeEntity
+	return nil
 }
 
-// handleRequests allows the stream to handle requests, so far only
-// the setting of the tags to filter.
+// handleRequests allows the stream to handle requests, reconfiguring
+// the line filters on each one received.
 func (stream *logStream) handleRequests(wsConn *websocket.Conn) {
 	for {
 		var req params.EntityLogRequest
@@ -134,7 +390,7 @@ func (stream *logStream) handleRequests(wsConn *websocket.Conn) {
 			stream.tomb.Kill(fmt.Errorf("error receiving packet: %v", err))
 			return
 		}
-		if err := stream.setFilter(req.Filter); err != nil {
+		if err := stream.configure(req); err != nil {
 			stream.tomb.Kill(fmt.Errorf("error setting filter: %v", err))
 			return
 		}
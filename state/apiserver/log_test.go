@@ -0,0 +1,14 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import gc "gopkg.in/check.v1"
+
+func (s *apiserverSuite) TestNewDebugLogHandlerSharesTheProcessWideMetrics(c *gc.C) {
+	h1 := newDebugLogHandler(nil)
+	h2 := newDebugLogHandler(nil)
+	c.Assert(h1.metrics, gc.Equals, debugLogMetrics)
+	c.Assert(h2.metrics, gc.Equals, debugLogMetrics)
+	c.Assert(h1.source, gc.NotNil)
+}
@@ -0,0 +1,132 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"code.google.com/p/go.net/websocket"
+
+	"launchpad.net/juju-core/state/apiserver/httpmetrics"
+)
+
+// logLineRx matches the line format written to all-machines.log:
+//
+//	<entity tag>: <date> <time> <severity> <module> <message>
+var logLineRx = regexp.MustCompile(
+	`^(?P<entity>\S+): (?P<timestamp>\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) (?P<severity>[A-Z]+) (?P<module>[\w.]+) (?P<message>.*)$`,
+)
+
+// logLineEnvelope is the JSON representation of a parsed log record,
+// streamed one per line when a debug-log request asks for
+// format=json.
+type logLineEnvelope struct {
+	Timestamp string `json:"timestamp"`
+	Entity    string `json:"entity,omitempty"`
+	Module    string `json:"module,omitempty"`
+	Severity  string `json:"severity,omitempty"`
+	Message   string `json:"message"`
+
+	// Context holds metadata about Entity, filled in only when the
+	// request asked for enrich=true and the entity could be
+	// resolved in time.
+	Context *entityInfo `json:"context,omitempty"`
+}
+
+// parseLogLine splits a raw all-machines.log line into its
+// constituent fields. It reports false if line does not match the
+// expected format, in which case only structural (non-field) based
+// filtering can be applied to it.
+func parseLogLine(line []byte) (logLineEnvelope, bool) {
+	// logLineRx's trailing $ anchors to the absolute end of the
+	// input, not end-of-line, so it never matches a line that still
+	// has its terminating newline -- which is how every line reaches
+	// us in practice. Match against a trimmed copy instead.
+	match := logLineRx.FindSubmatch(bytes.TrimRight(line, "\r\n"))
+	if match == nil {
+		return logLineEnvelope{}, false
+	}
+	names := logLineRx.SubexpNames()
+	rec := logLineEnvelope{}
+	for i, name := range names {
+		switch name {
+		case "entity":
+			rec.Entity = string(match[i])
+		case "timestamp":
+			rec.Timestamp = string(match[i])
+		case "severity":
+			rec.Severity = string(match[i])
+		case "module":
+			rec.Module = string(match[i])
+		case "message":
+			rec.Message = strings.TrimRight(string(match[i]), "\r\n")
+		}
+	}
+	return rec, true
+}
+
+// matchesAny reports whether value matches any of the given glob
+// patterns, as interpreted by path.Match. A malformed pattern never
+// matches.
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, value); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// logLineWriter wraps a websocket connection, converting each raw
+// log line it is given into a JSON envelope before writing it on.
+// It is only used when a debug-log request asks for format=json.
+type logLineWriter struct {
+	wsConn *websocket.Conn
+
+	// resolver, if non-nil, is used to decorate each envelope with
+	// contextual entity metadata (enrich=true).
+	resolver *entityResolver
+}
+
+// Write implements io.Writer. It is called by the tailer once per
+// delivered log line.
+func (w *logLineWriter) Write(line []byte) (int, error) {
+	rec, ok := parseLogLine(line)
+	if !ok {
+		rec = logLineEnvelope{Message: strings.TrimRight(string(line), "\r\n")}
+	}
+	if w.resolver != nil && rec.Entity != "" {
+		if info, ok := w.resolver.resolve(rec.Entity); ok {
+			rec.Context = info
+		}
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return 0, err
+	}
+	data = append(data, '\n')
+	if _, err := w.wsConn.Write(data); err != nil {
+		return 0, err
+	}
+	return len(line), nil
+}
+
+// meteringWriter wraps a writer, recording the number of bytes
+// written through it in metrics.
+type meteringWriter struct {
+	io.Writer
+	metrics *httpmetrics.Collector
+}
+
+// Write implements io.Writer.
+func (w *meteringWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.metrics.BytesStreamed(debugLogEndpoint, n)
+	return n, err
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	gc "gopkg.in/check.v1"
+
+	"launchpad.net/juju-core/state/api/params"
+	"launchpad.net/juju-core/state/apiserver/httpmetrics"
+)
+
+func (s *apiserverSuite) TestParseLogLine(c *gc.C) {
+	rec, ok := parseLogLine([]byte("machine-0: 2014-01-01 00:00:00 INFO juju.test a message\n"))
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(rec.Entity, gc.Equals, "machine-0")
+	c.Assert(rec.Severity, gc.Equals, "INFO")
+	c.Assert(rec.Module, gc.Equals, "juju.test")
+	c.Assert(rec.Message, gc.Equals, "a message")
+
+	_, ok = parseLogLine([]byte("not a log line\n"))
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *apiserverSuite) TestMatchesAny(c *gc.C) {
+	tests := []struct {
+		patterns []string
+		value    string
+		want     bool
+	}{
+		{nil, "juju.worker", false},
+		{[]string{"juju.*"}, "juju.worker", true},
+		{[]string{"juju.*"}, "other.worker", false},
+		{[]string{"unit-mysql-*"}, "unit-mysql-0", true},
+		{[]string{"unit-mysql-*", "machine-*"}, "machine-0", true},
+		{[]string{"["}, "anything", false}, // malformed pattern never matches
+	}
+	for _, test := range tests {
+		got := matchesAny(test.patterns, test.value)
+		c.Check(got, gc.Equals, test.want, gc.Commentf("matchesAny(%v, %q)", test.patterns, test.value))
+	}
+}
+
+func newTestStream(c *gc.C, req params.EntityLogRequest) *logStream {
+	stream := &logStream{metrics: httpmetrics.New()}
+	err := stream.configure(req)
+	c.Assert(err, gc.IsNil)
+	return stream
+}
+
+func (s *apiserverSuite) TestStreamMatchesSeverity(c *gc.C) {
+	stream := newTestStream(c, params.EntityLogRequest{Level: "WARNING"})
+	info := []byte("machine-0: 2014-01-01 00:00:00 INFO juju.test a message\n")
+	warning := []byte("machine-0: 2014-01-01 00:00:00 WARNING juju.test a message\n")
+	c.Check(stream.matches(info), gc.Equals, false)
+	c.Check(stream.matches(warning), gc.Equals, true)
+}
+
+func (s *apiserverSuite) TestStreamMatchesModuleGlobs(c *gc.C) {
+	stream := newTestStream(c, params.EntityLogRequest{
+		IncludeModule: []string{"juju.worker.*"},
+		ExcludeModule: []string{"juju.worker.noisy"},
+	})
+	included := []byte("machine-0: 2014-01-01 00:00:00 INFO juju.worker.uniter a message\n")
+	excluded := []byte("machine-0: 2014-01-01 00:00:00 INFO juju.worker.noisy a message\n")
+	unrelated := []byte("machine-0: 2014-01-01 00:00:00 INFO juju.apiserver a message\n")
+	c.Check(stream.matches(included), gc.Equals, true)
+	c.Check(stream.matches(excluded), gc.Equals, false)
+	c.Check(stream.matches(unrelated), gc.Equals, false)
+}
+
+func (s *apiserverSuite) TestStreamMatchesEntityGlobs(c *gc.C) {
+	stream := newTestStream(c, params.EntityLogRequest{
+		IncludeEntity: []string{"unit-mysql-*"},
+	})
+	matching := []byte("unit-mysql-0: 2014-01-01 00:00:00 INFO juju.test a message\n")
+	other := []byte("machine-0: 2014-01-01 00:00:00 INFO juju.test a message\n")
+	c.Check(stream.matches(matching), gc.Equals, true)
+	c.Check(stream.matches(other), gc.Equals, false)
+}
+
+func (s *apiserverSuite) TestStreamMatchesRegexFilter(c *gc.C) {
+	stream := newTestStream(c, params.EntityLogRequest{Filter: "wanted"})
+	matching := []byte("machine-0: 2014-01-01 00:00:00 INFO juju.test wanted text\n")
+	other := []byte("machine-0: 2014-01-01 00:00:00 INFO juju.test unrelated text\n")
+	c.Check(stream.matches(matching), gc.Equals, true)
+	c.Check(stream.matches(other), gc.Equals, false)
+}
+
+func (s *apiserverSuite) TestConfigureRejectsUnknownLevel(c *gc.C) {
+	stream := &logStream{metrics: httpmetrics.New()}
+	err := stream.configure(params.EntityLogRequest{Level: "NOT-A-LEVEL"})
+	c.Assert(err, gc.NotNil)
+}
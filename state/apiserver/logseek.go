@@ -0,0 +1,218 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// logLineTimeFormat is the timestamp layout used in all-machines.log
+// lines; it must match the "timestamp" group in logLineRx.
+const logLineTimeFormat = "2006-01-02 15:04:05"
+
+// seekProbeSize is the size, in bytes, of the first geometric probe
+// made while searching for since; it doubles on each subsequent
+// probe.
+const seekProbeSize = 4096
+
+// parseLogTime parses a since/until query value, which may be an
+// RFC3339 timestamp or a duration (e.g. "10m") relative to now. An
+// empty value returns the zero Time and is not an error.
+func parseLogTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC3339 timestamp or duration (e.g. %q): %v", "10m", err)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// seekLogFile positions r at the first line whose leading timestamp
+// is at or after since. It samples the file at geometrically
+// increasing offsets to bracket the target, then narrows with a
+// binary search. If the file turns out to be smaller than the
+// sampled offsets expect -- as happens when a rotated log has been
+// truncated out from underneath us -- it falls back to a linear
+// scan from the start instead of failing outright.
+func seekLogFile(r io.ReadSeeker, since time.Time) error {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		_, err := r.Seek(0, io.SeekStart)
+		return err
+	}
+
+	offset, err := bracketSearch(r, since, size)
+	if err != nil {
+		// The file is smaller than we expected (probably rotated
+		// since we measured it), or some other read error got in
+		// the way. Either way, fall back to a plain linear scan.
+		offset, err = linearSearch(r, since, size)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = r.Seek(offset, io.SeekStart)
+	return err
+}
+
+// bracketSearch implements the geometric-probe-then-binary-search
+// part of seekLogFile. lo and hi are tracked as the offsets where
+// known lines start (never mid-line), so the window they bracket can
+// be linearly rescanned at the end to land exactly on a line
+// boundary.
+func bracketSearch(r io.ReadSeeker, since time.Time, size int64) (int64, error) {
+	var lo int64
+	hi := size
+	for probe := int64(seekProbeSize); probe < size; probe *= 2 {
+		lineOffset, ts, ok, err := readLeadingTimestamp(r, probe, size)
+		if err != nil {
+			return 0, err
+		}
+		if !ok || !ts.Before(since) {
+			hi = lineOffset
+			break
+		}
+		lo = lineOffset
+	}
+	for hi-lo > 512 {
+		mid := lo + (hi-lo)/2
+		lineOffset, ts, ok, err := readLeadingTimestamp(r, mid, size)
+		if err != nil {
+			return 0, err
+		}
+		// An unparseable timestamp is treated as matching any
+		// window, so we stop narrowing past it rather than risk
+		// skipping real content because of clock skew.
+		if ok && ts.Before(since) {
+			lo = lineOffset
+		} else if lineOffset < hi {
+			hi = lineOffset
+		} else {
+			// No line starts between mid and hi, so there is nothing
+			// left to narrow; scanWindow takes it from here.
+			break
+		}
+	}
+	// lo and hi are both line-aligned but may still be a handful of
+	// lines apart; scan that narrow window to land on the exact
+	// line boundary.
+	return scanWindow(r, since, lo, hi)
+}
+
+// scanWindow linearly rescans [lo, hi), both already known to be
+// line-aligned, returning the offset of the first line at or after
+// since (or hi, if every line in the window is before since).
+func scanWindow(r io.ReadSeeker, since time.Time, lo, hi int64) (int64, error) {
+	if _, err := r.Seek(lo, io.SeekStart); err != nil {
+		return 0, err
+	}
+	br := bufio.NewReader(r)
+	offset := lo
+	for offset < hi {
+		line, err := br.ReadString('\n')
+		if ts, ok := leadingTimestamp([]byte(line)); !ok || !ts.Before(since) {
+			return offset, nil
+		}
+		offset += int64(len(line))
+		if err != nil {
+			break
+		}
+	}
+	return offset, nil
+}
+
+// linearSearch scans the file from the start, returning the offset
+// of the first line at or after since.
+func linearSearch(r io.ReadSeeker, since time.Time, size int64) (int64, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	br := bufio.NewReader(r)
+	var offset int64
+	for {
+		line, err := br.ReadString('\n')
+		if ts, ok := leadingTimestamp([]byte(line)); !ok || !ts.Before(since) {
+			return offset, nil
+		}
+		offset += int64(len(line))
+		if err != nil {
+			// EOF with every line before since: nothing to return.
+			return offset, nil
+		}
+	}
+}
+
+// readLeadingTimestamp reads the first complete line starting at or
+// after offset, resyncing past any partial line the seek landed in
+// the middle of, and returns both its timestamp and the offset at
+// which that line actually begins -- never offset itself, unless
+// offset was already 0 or a line start. It reports false if offset is
+// beyond size or the line found could not be parsed, and returns an
+// error if the file turns out to hold less than size bytes.
+func readLeadingTimestamp(r io.ReadSeeker, offset, size int64) (lineStart int64, ts time.Time, ok bool, err error) {
+	if offset >= size {
+		return size, time.Time{}, false, nil
+	}
+	// Start one byte early, so that when offset is already a line
+	// start only the newline before it is discarded.
+	readFrom := offset
+	if offset > 0 {
+		readFrom = offset - 1
+	}
+	if _, err := r.Seek(readFrom, io.SeekStart); err != nil {
+		return offset, time.Time{}, false, err
+	}
+	br := bufio.NewReader(r)
+	lineStart = offset
+	if offset > 0 {
+		discarded, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return offset, time.Time{}, false, err
+		}
+		lineStart = readFrom + int64(len(discarded))
+	}
+	line, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return lineStart, time.Time{}, false, err
+	}
+	if line == "" && lineStart < size {
+		return lineStart, time.Time{}, false, fmt.Errorf("log file is shorter than its measured size of %d bytes", size)
+	}
+	ts, ok = leadingTimestamp([]byte(line))
+	return lineStart, ts, ok, nil
+}
+
+// leadingTimestamp parses the timestamp field out of a single
+// all-machines.log line.
+func leadingTimestamp(line []byte) (time.Time, bool) {
+	// As in parseLogLine, logLineRx's $ never matches with the
+	// terminating newline still attached.
+	match := logLineRx.FindSubmatch(bytes.TrimRight(line, "\r\n"))
+	if match == nil {
+		return time.Time{}, false
+	}
+	for i, name := range logLineRx.SubexpNames() {
+		if name != "timestamp" {
+			continue
+		}
+		ts, err := time.Parse(logLineTimeFormat, string(match[i]))
+		if err != nil {
+			return time.Time{}, false
+		}
+		return ts, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,107 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+// line builds a single all-machines.log line with the given
+// timestamp, for use in test fixtures.
+func line(ts string) string {
+	return "machine-0: " + ts + " INFO juju.test some message\n"
+}
+
+func mustParse(c *gc.C, value string) time.Time {
+	ts, err := time.Parse(logLineTimeFormat, value)
+	c.Assert(err, gc.IsNil)
+	return ts
+}
+
+// seekTestReadSeeker adapts a byte slice into an io.ReadSeeker, the
+// same shape seekLogFile is handed in production (an open *os.File).
+func seekTestReadSeeker(content string) *bytes.Reader {
+	return bytes.NewReader([]byte(content))
+}
+
+func (s *apiserverSuite) TestSeekLogFileLandsOnLineBoundary(c *gc.C) {
+	// Build a log file well past seekProbeSize so bracketSearch's
+	// geometric probing and binary narrowing both kick in, rather
+	// than going straight to the final scanWindow.
+	var buf bytes.Buffer
+	base := mustParse(c, "2014-01-01 00:00:00")
+	var wantOffset int64 = -1
+	since := base.Add(9999 * time.Second)
+	for i := 0; i < 20000; i++ {
+		ts := base.Add(time.Duration(i) * time.Second)
+		l := line(ts.Format(logLineTimeFormat))
+		if wantOffset < 0 && !ts.Before(since) {
+			wantOffset = int64(buf.Len())
+		}
+		buf.WriteString(l)
+	}
+	c.Assert(wantOffset, gc.Not(gc.Equals), int64(-1))
+
+	r := seekTestReadSeeker(buf.String())
+	err := seekLogFile(r, since)
+	c.Assert(err, gc.IsNil)
+	gotOffset, err := r.Seek(0, 1) // io.SeekCurrent
+	c.Assert(err, gc.IsNil)
+	c.Assert(gotOffset, gc.Equals, wantOffset)
+
+	rest := make([]byte, 4096)
+	n, _ := r.Read(rest)
+	got := string(rest[:n])
+	want := line(since.Format(logLineTimeFormat))
+	c.Assert(strings.HasPrefix(got, want), gc.Equals, true, gc.Commentf("got %q, want prefix %q", got, want))
+}
+
+func (s *apiserverSuite) TestSeekLogFileEmptyFile(c *gc.C) {
+	r := seekTestReadSeeker("")
+	err := seekLogFile(r, time.Now())
+	c.Assert(err, gc.IsNil)
+	offset, err := r.Seek(0, 1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(offset, gc.Equals, int64(0))
+}
+
+func (s *apiserverSuite) TestSeekLogFileSinceBeforeFirstLine(c *gc.C) {
+	content := line("2014-01-01 00:00:00") + line("2014-01-01 00:00:01")
+	r := seekTestReadSeeker(content)
+	since := mustParse(c, "2013-01-01 00:00:00")
+	err := seekLogFile(r, since)
+	c.Assert(err, gc.IsNil)
+	offset, err := r.Seek(0, 1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(offset, gc.Equals, int64(0))
+}
+
+func (s *apiserverSuite) TestReadLeadingTimestampResyncsPastPartialLine(c *gc.C) {
+	first := line("2014-01-01 00:00:00")
+	second := line("2014-01-01 00:00:01")
+	content := first + second
+
+	// offset lands in the middle of the first line -- readLeadingTimestamp
+	// must resync to the start of the second line, not return a
+	// timestamp parsed from a byte offset mid-way through the first.
+	offset := int64(len(first) / 2)
+	lineStart, ts, ok, err := readLeadingTimestamp(seekTestReadSeeker(content), offset, int64(len(content)))
+	c.Assert(err, gc.IsNil)
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(lineStart, gc.Equals, int64(len(first)))
+	want := mustParse(c, "2014-01-01 00:00:01")
+	c.Assert(ts.Equal(want), gc.Equals, true, gc.Commentf("ts = %v, want %v", ts, want))
+}
+
+func (s *apiserverSuite) TestLinearSearchFallback(c *gc.C) {
+	content := line("2014-01-01 00:00:00") + line("2014-01-01 00:00:05") + line("2014-01-01 00:00:10")
+	since := mustParse(c, "2014-01-01 00:00:05")
+	offset, err := linearSearch(seekTestReadSeeker(content), since, int64(len(content)))
+	c.Assert(err, gc.IsNil)
+	c.Assert(offset, gc.Equals, int64(len(line("2014-01-01 00:00:00"))))
+}
@@ -0,0 +1,51 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ReadSeekCloser is what a LogSource hands back to be read, sought
+// within (for the "lines" tail-count and the since/until window) and
+// eventually closed.
+type ReadSeekCloser interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// LogSource abstracts over where debug-log reads its lines from, so
+// that logHandler can serve the local all-machines.log, the systemd
+// journal, or a set of rotated log files without caring which. A
+// LogSource is chosen once, when the apiserver is constructed.
+type LogSource interface {
+	// Open returns a fresh handle onto the source's content. since is
+	// the request's since filter (the zero Time if none was given);
+	// a source that has to assemble its content from multiple files
+	// may use it to skip ones it can tell are entirely too old,
+	// without needing to know anything else about the request.
+	Open(ctx context.Context, since time.Time) (ReadSeekCloser, error)
+
+	// Follow reports whether a handle from Open should be treated as
+	// a live tail -- i.e. whether reading past the current end of
+	// content should wait for more rather than return EOF. Sources
+	// that can only replay a bounded snapshot return false.
+	Follow() bool
+}
+
+// readSeekerNopCloser adapts a bytes.Reader, which has no Close
+// method, to ReadSeekCloser.
+type readSeekerNopCloser struct {
+	*bytes.Reader
+}
+
+// Close implements io.Closer.
+func (readSeekerNopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// fileLogSource is the original LogSource: a single flat file, such
+// as /var/log/juju/all-machines.log.
+type fileLogSource struct {
+	path string
+}
+
+// newFileLogSource returns a LogSource that reads the file at path.
+func newFileLogSource(path string) LogSource {
+	return &fileLogSource{path: path}
+}
+
+// Open implements LogSource. since is unused: the file has nothing
+// else to skip past, it's the only thing there is to read.
+func (s *fileLogSource) Open(ctx context.Context, since time.Time) (ReadSeekCloser, error) {
+	return os.Open(s.path)
+}
+
+// Follow implements LogSource. A flat file is always followed: new
+// lines appended to it after Open are delivered as they arrive.
+func (s *fileLogSource) Follow() bool {
+	return true
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build linux
+
+package apiserver
+
+import gc "gopkg.in/check.v1"
+
+func (s *apiserverSuite) TestJournalPriorityToSeverity(c *gc.C) {
+	tests := []struct {
+		priority string
+		want     string
+	}{
+		{"0", "CRITICAL"},
+		{"1", "CRITICAL"},
+		{"2", "CRITICAL"},
+		{"3", "ERROR"},
+		{"4", "WARNING"},
+		{"5", "INFO"},
+		{"6", "INFO"},
+		{"7", "DEBUG"},
+		{"garbage", "INFO"},
+	}
+	for _, test := range tests {
+		got := journalPriorityToSeverity(test.priority)
+		c.Check(got, gc.Equals, test.want, gc.Commentf("journalPriorityToSeverity(%q)", test.priority))
+	}
+}
+
+func (s *apiserverSuite) TestUnitToEntityTag(c *gc.C) {
+	tests := []struct {
+		unit string
+		want string
+	}{
+		{"jujud-machine-0.service", "machine-0"},
+		{"jujud-unit-mysql-0.service", "unit-mysql-0"},
+		{"other.service", "other"},
+	}
+	for _, test := range tests {
+		got := unitToEntityTag(test.unit)
+		c.Check(got, gc.Equals, test.want, gc.Commentf("unitToEntityTag(%q)", test.unit))
+	}
+}
+
+func (s *apiserverSuite) TestJujuUnitGlobMatchesRealJujudUnitNames(c *gc.C) {
+	// jujud-*.service must actually match the unit names jujud runs
+	// under -- sd_journal_add_match can't glob-filter these itself
+	// (see journaldLogSource.Open), so matchesAny is what stands in
+	// for the journal API here, and it only helps if the glob is
+	// right.
+	tests := []struct {
+		unit string
+		want bool
+	}{
+		{"jujud-machine-0.service", true},
+		{"jujud-unit-mysql-0.service", true},
+		{"sshd.service", false},
+		{"cron.service", false},
+	}
+	for _, test := range tests {
+		got := matchesAny([]string{jujuUnitGlob}, test.unit)
+		c.Check(got, gc.Equals, test.want, gc.Commentf("matchesAny(%q, %q)", jujuUnitGlob, test.unit))
+	}
+}
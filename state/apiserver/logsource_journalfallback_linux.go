@@ -0,0 +1,18 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build linux
+
+package apiserver
+
+// jujuUnitGlob restricts newJournalFallback's journaldLogSource to
+// the juju agent units -- jujud-machine-0.service,
+// jujud-unit-mysql-0.service, and so on, per unitToEntityTag.
+const jujuUnitGlob = "jujud-*.service"
+
+// newJournalFallback returns a journaldLogSource for newDefaultLogSource
+// to fall back to when all-machines.log isn't present. ok is always
+// true on linux, where the journal is available.
+func newJournalFallback() (source LogSource, ok bool) {
+	return newJournaldLogSource(jujuUnitGlob), true
+}
@@ -0,0 +1,13 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build !linux
+
+package apiserver
+
+// newJournalFallback reports ok=false: the systemd journal is only
+// available on linux, so newDefaultLogSource sticks with the flat
+// file source everywhere else.
+func newJournalFallback() (source LogSource, ok bool) {
+	return nil, false
+}
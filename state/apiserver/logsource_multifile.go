@@ -0,0 +1,185 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// copyChunkSize bounds how much of a single backup is copied between
+// ctx.Err() checks, so that a large backup can't block past
+// logSourceOpenTimeout unnoticed.
+const copyChunkSize = 256 * 1024
+
+// multiFileLogSource is a LogSource that stitches together a current
+// log file and its logrotate-style backups (path, path.1, path.2.gz,
+// ...) into a single chronological stream, so that a since= reaching
+// back past the current file isn't cut short at its start.
+type multiFileLogSource struct {
+	path string
+}
+
+// newMultiFileLogSource returns a LogSource that merges path with
+// any rotated backups found alongside it.
+func newMultiFileLogSource(path string) LogSource {
+	return &multiFileLogSource{path: path}
+}
+
+// Open implements LogSource. It copies every rotated backup that
+// since tells us might still be relevant, oldest first, followed by
+// the current file, into a spooled temporary file and returns that --
+// the merge cost is paid once per connection rather than once per
+// line.
+func (s *multiFileLogSource) Open(ctx context.Context, since time.Time) (ReadSeekCloser, error) {
+	backups, err := rotatedBackups(s.path)
+	if err != nil {
+		return nil, err
+	}
+	backups = skipOldBackups(backups, since)
+	spool, err := ioutil.TempFile("", "juju-debug-log-")
+	if err != nil {
+		return nil, err
+	}
+	// Unlinked immediately: the spool's content lives as long as the
+	// open file descriptor, and is freed automatically on Close.
+	os.Remove(spool.Name())
+
+	for _, backup := range append(backups, s.path) {
+		if ctx.Err() != nil {
+			spool.Close()
+			return nil, ctx.Err()
+		}
+		if err := copyLogFile(ctx, spool, backup); err != nil {
+			spool.Close()
+			return nil, fmt.Errorf("cannot merge %s: %v", backup, err)
+		}
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		return nil, err
+	}
+	return spool, nil
+}
+
+// skipOldBackups drops the leading backups (oldest first) whose mtime
+// shows they were last written to before since, i.e. every line in
+// them predates since. A backup's mtime is always at or after its
+// last line's timestamp, so this can only under-skip, never cause a
+// wanted line to be dropped. If since is zero, every backup is kept.
+func skipOldBackups(backups []string, since time.Time) []string {
+	if since.IsZero() {
+		return backups
+	}
+	for i, backup := range backups {
+		info, err := os.Stat(backup)
+		if err != nil || !info.ModTime().Before(since) {
+			return backups[i:]
+		}
+	}
+	return nil
+}
+
+// Follow implements LogSource. Once merged, the spool is a fixed
+// snapshot; following the live file across a rotation boundary isn't
+// supported.
+func (s *multiFileLogSource) Follow() bool {
+	return false
+}
+
+// rotatedBackups returns the logrotate-style backups of path --
+// path.N and path.N.gz -- oldest first.
+func rotatedBackups(path string) ([]string, error) {
+	dir, base := filepath.Split(path)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups backupsByNumberDesc
+	for _, entry := range entries {
+		rest := strings.TrimPrefix(entry.Name(), base+".")
+		if rest == entry.Name() {
+			continue // doesn't start with "<base>."
+		}
+		rest = strings.TrimSuffix(rest, ".gz")
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			continue // not a logrotate-style suffix
+		}
+		backups = append(backups, backupFile{n, entry.Name()})
+	}
+	sort.Sort(backups)
+	result := make([]string, len(backups))
+	for i, b := range backups {
+		result[i] = filepath.Join(dir, b.name)
+	}
+	return result, nil
+}
+
+// backupFile is a single rotated backup found by rotatedBackups.
+type backupFile struct {
+	n    int
+	name string
+}
+
+// backupsByNumberDesc sorts backupFiles by descending n, i.e. oldest
+// first (logrotate numbers its newest backup .1). It is a sort.Slice
+// stand-in so this package doesn't require Go 1.8, matching the
+// golang.org/x/net/context import chosen over stdlib context
+// elsewhere in this source.
+type backupsByNumberDesc []backupFile
+
+func (b backupsByNumberDesc) Len() int           { return len(b) }
+func (b backupsByNumberDesc) Less(i, j int) bool { return b[i].n > b[j].n }
+func (b backupsByNumberDesc) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// copyLogFile appends path's content to w, transparently
+// decompressing it first if path is gzipped. It copies in chunks,
+// checking ctx between them, so a single large backup can't run past
+// the caller's deadline unnoticed the way one big io.Copy could.
+func copyLogFile(ctx context.Context, w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		_, err := io.CopyN(w, r, copyChunkSize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	gc "gopkg.in/check.v1"
+	"golang.org/x/net/context"
+)
+
+func (s *apiserverSuite) TestRotatedBackupsSortOrder(c *gc.C) {
+	base := filepath.Join(s.dir, "all-machines.log")
+	for _, name := range []string{"all-machines.log", "all-machines.log.1", "all-machines.log.2.gz", "all-machines.log.10", "unrelated.log"} {
+		err := ioutil.WriteFile(filepath.Join(s.dir, name), nil, 0644)
+		c.Assert(err, gc.IsNil)
+	}
+
+	backups, err := rotatedBackups(base)
+	c.Assert(err, gc.IsNil)
+	want := []string{
+		filepath.Join(s.dir, "all-machines.log.10"),
+		filepath.Join(s.dir, "all-machines.log.2.gz"),
+		filepath.Join(s.dir, "all-machines.log.1"),
+	}
+	c.Assert(backups, gc.DeepEquals, want)
+}
+
+func (s *apiserverSuite) TestSkipOldBackupsKeepsEverythingWhenSinceIsZero(c *gc.C) {
+	backups := []string{"a", "b", "c"}
+	c.Assert(skipOldBackups(backups, time.Time{}), gc.DeepEquals, backups)
+}
+
+func (s *apiserverSuite) TestSkipOldBackupsDropsOnlyBackupsEntirelyBeforeSince(c *gc.C) {
+	now := time.Now()
+	old := filepath.Join(s.dir, "old")
+	recent := filepath.Join(s.dir, "recent")
+	c.Assert(ioutil.WriteFile(old, nil, 0644), gc.IsNil)
+	c.Assert(ioutil.WriteFile(recent, nil, 0644), gc.IsNil)
+	c.Assert(os.Chtimes(old, now.Add(-time.Hour), now.Add(-time.Hour)), gc.IsNil)
+	c.Assert(os.Chtimes(recent, now, now), gc.IsNil)
+
+	got := skipOldBackups([]string{old, recent}, now.Add(-time.Minute))
+	c.Assert(got, gc.DeepEquals, []string{recent})
+}
+
+func (s *apiserverSuite) TestCopyLogFileCopiesAcrossChunkBoundaries(c *gc.C) {
+	path := filepath.Join(s.dir, "big")
+	content := bytes.Repeat([]byte("x"), copyChunkSize*2+17)
+	c.Assert(ioutil.WriteFile(path, content, 0644), gc.IsNil)
+
+	var buf bytes.Buffer
+	err := copyLogFile(context.Background(), &buf, path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(buf.Bytes(), gc.DeepEquals, content)
+}
+
+func (s *apiserverSuite) TestCopyLogFileRespectsCancellation(c *gc.C) {
+	path := filepath.Join(s.dir, "big")
+	content := bytes.Repeat([]byte("x"), copyChunkSize*4)
+	c.Assert(ioutil.WriteFile(path, content, 0644), gc.IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	err := copyLogFile(ctx, &buf, path)
+	c.Assert(err, gc.Equals, ctx.Err())
+}
+
+func (s *apiserverSuite) TestCopyLogFileMissingFileIsNotAnError(c *gc.C) {
+	var buf bytes.Buffer
+	err := copyLogFile(context.Background(), &buf, "/no/such/file")
+	c.Assert(err, gc.IsNil)
+	c.Assert(buf.Len(), gc.Equals, 0)
+}
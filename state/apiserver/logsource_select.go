@@ -0,0 +1,25 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import "os"
+
+// newDefaultLogSource picks the LogSource newLogHandler should be
+// constructed with for the all-machines.log at path, based on what's
+// actually on disk: rotated backups alongside path are merged in, an
+// existing flat file is read directly, and -- on platforms where
+// it's available -- a missing file falls back to the systemd journal
+// rather than failing outright. This is the selection apiserver
+// construction is meant to call; see newLogHandler.
+func newDefaultLogSource(path string) LogSource {
+	if backups, err := rotatedBackups(path); err == nil && len(backups) > 0 {
+		return newMultiFileLogSource(path)
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if source, ok := newJournalFallback(); ok {
+			return source
+		}
+	}
+	return newFileLogSource(path)
+}
@@ -0,0 +1,17 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build linux
+
+package apiserver
+
+import (
+	"path/filepath"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *apiserverSuite) TestNewDefaultLogSourceFallsBackToJournaldWhenFileIsMissing(c *gc.C) {
+	source := newDefaultLogSource(filepath.Join(s.dir, "all-machines.log"))
+	c.Assert(source, gc.FitsTypeOf, &journaldLogSource{})
+}
@@ -0,0 +1,28 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *apiserverSuite) TestNewDefaultLogSourcePicksFileWhenNoBackups(c *gc.C) {
+	path := filepath.Join(s.dir, "all-machines.log")
+	c.Assert(ioutil.WriteFile(path, []byte("a line\n"), 0644), gc.IsNil)
+
+	source := newDefaultLogSource(path)
+	c.Assert(source, gc.FitsTypeOf, &fileLogSource{})
+}
+
+func (s *apiserverSuite) TestNewDefaultLogSourcePicksMultiFileWhenBackupsExist(c *gc.C) {
+	path := filepath.Join(s.dir, "all-machines.log")
+	c.Assert(ioutil.WriteFile(path, []byte("a line\n"), 0644), gc.IsNil)
+	c.Assert(ioutil.WriteFile(path+".1", []byte("an older line\n"), 0644), gc.IsNil)
+
+	source := newDefaultLogSource(path)
+	c.Assert(source, gc.FitsTypeOf, &multiFileLogSource{})
+}
@@ -0,0 +1,27 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	stdtesting "testing"
+
+	gc "gopkg.in/check.v1"
+)
+
+func Test(t *stdtesting.T) {
+	gc.TestingT(t)
+}
+
+// apiserverSuite is the shared gocheck suite for this package's
+// tests, so the log-source tests that need a scratch directory don't
+// each have to set up and tear down their own.
+type apiserverSuite struct {
+	dir string
+}
+
+var _ = gc.Suite(&apiserverSuite{})
+
+func (s *apiserverSuite) SetUpTest(c *gc.C) {
+	s.dir = c.MkDir()
+}